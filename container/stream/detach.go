@@ -0,0 +1,122 @@
+package stream // import "github.com/docker/docker/container/stream"
+
+import (
+	"errors"
+	"io"
+)
+
+// DetachError is reported over a Config's detach channel when the
+// configured detach-keys sequence is read from stdin.
+type DetachError struct{}
+
+// Error implements error.
+func (DetachError) Error() string {
+	return "read detach keys"
+}
+
+// SetDetachKeys configures the byte sequence (e.g. ctrl-p,ctrl-q) that,
+// when read from stdin, detaches the session instead of forwarding the
+// bytes to the container. A nil or empty seq disables detection.
+func (c *Config) SetDetachKeys(seq []byte) {
+	c.detachKeys = seq
+}
+
+// DetachNotify returns a channel that receives a DetachError once the
+// configured detach-keys sequence has been read from stdin. It is safe
+// to call before or after CopyToPipe: the channel is allocated once, in
+// NewConfig.
+func (c *Config) DetachNotify() <-chan error {
+	return c.detachNotify
+}
+
+// detachKeysLPS returns the KMP failure function for keys: lps[i] is the
+// length of the longest proper prefix of keys[:i+1] that is also a
+// suffix of it. It lets detachCopy fall back to a partial match instead
+// of restarting from scratch whenever a byte diverges.
+func detachKeysLPS(keys []byte) []int {
+	lps := make([]int, len(keys))
+	length := 0
+	for i := 1; i < len(keys); {
+		if keys[i] == keys[length] {
+			length++
+			lps[i] = length
+			i++
+		} else if length != 0 {
+			length = lps[length-1]
+		} else {
+			lps[i] = 0
+			i++
+		}
+	}
+	return lps
+}
+
+// detachCopy copies from src to dst, scanning for c.detachKeys along the
+// way using the standard KMP automaton. Bytes that are part of a
+// partial match are buffered — never forwarded to dst — until the match
+// either completes, in which case copying stops and onDetach is
+// invoked, or falls back to a shorter (possibly empty) partial match, in
+// which case the prefix that can no longer be part of any match is
+// flushed to dst before continuing. If no detach keys are configured,
+// detachCopy is a plain io.Copy.
+func (c *Config) detachCopy(dst io.Writer, src io.Reader, onDetach func() error) error {
+	keys := c.detachKeys
+	if len(keys) == 0 {
+		_, err := io.Copy(dst, src)
+		return err
+	}
+	lps := detachKeysLPS(keys)
+
+	var (
+		matchBuf []byte // currently buffered bytes; always len(matchBuf) == matched
+		matched  int
+		b        [1]byte
+	)
+	flushPrefix := func(n int) error {
+		if n <= 0 {
+			return nil
+		}
+		if _, err := dst.Write(matchBuf[:n]); err != nil {
+			return err
+		}
+		matchBuf = append(matchBuf[:0], matchBuf[n:]...)
+		return nil
+	}
+
+	for {
+		n, rerr := src.Read(b[:])
+		if n > 0 {
+			ch := b[0]
+			for {
+				if ch == keys[matched] {
+					matched++
+					matchBuf = append(matchBuf, ch)
+					if matched == len(keys) {
+						return onDetach()
+					}
+					break
+				}
+				if matched == 0 {
+					if _, werr := dst.Write(b[:1]); werr != nil {
+						return werr
+					}
+					break
+				}
+				fallback := lps[matched-1]
+				if err := flushPrefix(matched - fallback); err != nil {
+					return err
+				}
+				matched = fallback
+			}
+		}
+		if rerr != nil {
+			if err := flushPrefix(len(matchBuf)); err != nil {
+				return err
+			}
+			if errors.Is(rerr, io.EOF) {
+				return nil
+			}
+			return rerr
+		}
+	}
+}
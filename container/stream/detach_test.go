@@ -0,0 +1,150 @@
+package stream
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func detachOnMatch(matched *bool) func() error {
+	return func() error {
+		*matched = true
+		return nil
+	}
+}
+
+func TestDetachCopyDisabledWithEmptyKeys(t *testing.T) {
+	c := NewConfig()
+
+	var dst bytes.Buffer
+	matched := false
+	if err := c.detachCopy(&dst, strings.NewReader("hello world"), detachOnMatch(&matched)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if matched {
+		t.Fatal("onDetach should not fire when no detach keys are configured")
+	}
+	if dst.String() != "hello world" {
+		t.Fatalf("expected %q, got %q", "hello world", dst.String())
+	}
+}
+
+func TestDetachCopyMatchAcrossReads(t *testing.T) {
+	c := NewConfig()
+	c.SetDetachKeys([]byte{16, 17}) // ctrl-p, ctrl-q
+
+	src := &chunkedReader{chunks: [][]byte{
+		[]byte("hello "),
+		{16},
+		{17},
+		[]byte("never reaches dst"),
+	}}
+
+	var dst bytes.Buffer
+	matched := false
+	if err := c.detachCopy(&dst, src, detachOnMatch(&matched)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !matched {
+		t.Fatal("expected onDetach to fire")
+	}
+	if dst.String() != "hello " {
+		t.Fatalf("expected %q, got %q", "hello ", dst.String())
+	}
+}
+
+func TestDetachCopyNearMatchThenDiverge(t *testing.T) {
+	c := NewConfig()
+	c.SetDetachKeys([]byte{16, 17})
+
+	// ctrl-p followed by a plain byte (not ctrl-q): the buffered ctrl-p
+	// must be flushed, then the diverging byte copied through.
+	src := bytes.NewReader([]byte{'a', 16, 'b', 'c'})
+
+	var dst bytes.Buffer
+	matched := false
+	if err := c.detachCopy(&dst, src, detachOnMatch(&matched)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if matched {
+		t.Fatal("onDetach should not fire on a near-match")
+	}
+	if want := string([]byte{'a', 16, 'b', 'c'}); dst.String() != want {
+		t.Fatalf("expected %q, got %q", want, dst.String())
+	}
+}
+
+func TestDetachCopyDivergeReentersSequence(t *testing.T) {
+	c := NewConfig()
+	c.SetDetachKeys([]byte{16, 16, 17})
+
+	// ctrl-p, ctrl-p, ctrl-p, ctrl-q: the sequence {16,16,17} actually
+	// occurs contiguously starting at the second byte, so the match must
+	// be found via the KMP failure function falling back to a 1-byte
+	// partial match (the second ctrl-p) instead of restarting from
+	// scratch. Only the very first ctrl-p — which can never be part of
+	// that match — is flushed to dst.
+	src := bytes.NewReader([]byte{16, 16, 16, 17})
+
+	var dst bytes.Buffer
+	matched := false
+	if err := c.detachCopy(&dst, src, detachOnMatch(&matched)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !matched {
+		t.Fatal("expected onDetach to fire")
+	}
+	if want := string([]byte{16}); dst.String() != want {
+		t.Fatalf("expected %q, got %q", want, dst.String())
+	}
+}
+
+func TestDetachCopyEmptyAndNilKeysDisabled(t *testing.T) {
+	for _, keys := range [][]byte{nil, {}} {
+		c := NewConfig()
+		c.SetDetachKeys(keys)
+
+		var dst bytes.Buffer
+		matched := false
+		if err := c.detachCopy(&dst, strings.NewReader("passthrough"), detachOnMatch(&matched)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if matched {
+			t.Fatal("onDetach should not fire when detach keys are disabled")
+		}
+		if dst.String() != "passthrough" {
+			t.Fatalf("expected %q, got %q", "passthrough", dst.String())
+		}
+	}
+}
+
+func TestDetachNotifyReturnsTheSameChannel(t *testing.T) {
+	c := NewConfig()
+
+	a := c.DetachNotify()
+	b := c.DetachNotify()
+	if a != b {
+		t.Fatal("expected repeated calls to DetachNotify to return the same channel")
+	}
+}
+
+// chunkedReader returns at most one chunk per Read call (the rest of a
+// chunk is returned by later calls when p is shorter), simulating a
+// sequence split across multiple underlying reads.
+type chunkedReader struct {
+	chunks [][]byte
+	i      int
+}
+
+func (r *chunkedReader) Read(p []byte) (int, error) {
+	if r.i >= len(r.chunks) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.chunks[r.i])
+	r.chunks[r.i] = r.chunks[r.i][n:]
+	if len(r.chunks[r.i]) == 0 {
+		r.i++
+	}
+	return n, nil
+}
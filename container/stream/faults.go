@@ -0,0 +1,42 @@
+package stream // import "github.com/docker/docker/container/stream"
+
+import (
+	"context"
+	"io"
+
+	"github.com/docker/docker/container/stream/toxics"
+)
+
+// AddStdoutToxic appends a fault to the chain applied to stdout ahead
+// of any Transformation filters, so tests can reproduce flaky-network
+// scenarios. The toxic chains are read when CopyToPipe is called;
+// toxics added after that do not affect the running copy.
+func (c *Config) AddStdoutToxic(t toxics.Toxic) {
+	c.stdoutToxics = append(c.stdoutToxics, t)
+}
+
+// AddStderrToxic appends a fault to the chain applied to stderr ahead
+// of any Transformation filters.
+func (c *Config) AddStderrToxic(t toxics.Toxic) {
+	c.stderrToxics = append(c.stderrToxics, t)
+}
+
+// AddStdinToxic appends a fault to the chain applied to stdin ahead of
+// any Transformation filters.
+func (c *Config) AddStdinToxic(t toxics.Toxic) {
+	c.stdinToxics = append(c.stdinToxics, t)
+}
+
+// withToxics wraps src so that everything read from the result has
+// first passed through the given toxics, in order. With no toxics, src
+// is returned unchanged.
+func withToxics(ctx context.Context, src io.Reader, tx []toxics.Toxic) io.Reader {
+	if len(tx) == 0 {
+		return src
+	}
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(toxics.Chain(ctx, w, src, tx...))
+	}()
+	return r
+}
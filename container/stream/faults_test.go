@@ -0,0 +1,20 @@
+package stream
+
+import (
+	"testing"
+
+	"github.com/docker/docker/container/stream/toxics"
+)
+
+func TestAddToxicsAppendsToTheRightChain(t *testing.T) {
+	c := NewConfig()
+
+	c.AddStdoutToxic(toxics.LimitData{Bytes: 1})
+	c.AddStderrToxic(toxics.LimitData{Bytes: 2})
+	c.AddStdinToxic(toxics.LimitData{Bytes: 3})
+
+	if len(c.stdoutToxics) != 1 || len(c.stderrToxics) != 1 || len(c.stdinToxics) != 1 {
+		t.Fatalf("expected each AddXToxic to append to its own chain, got stdout=%d stderr=%d stdin=%d",
+			len(c.stdoutToxics), len(c.stderrToxics), len(c.stdinToxics))
+	}
+}
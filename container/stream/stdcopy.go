@@ -0,0 +1,169 @@
+package stream // import "github.com/docker/docker/container/stream"
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// StdType is the type of standard stream a frame in the multiplexed
+// attach/logs protocol belongs to.
+type StdType byte
+
+const (
+	// Stdin represents standard input stream type.
+	Stdin StdType = iota
+	// Stdout represents standard output stream type.
+	Stdout
+	// Stderr represents standard error stream type.
+	Stderr
+	// Systemerr represents errors originating from the system that
+	// forwarded the streams, rather than from the container itself.
+	Systemerr
+
+	stdWriterPrefixLen = 8
+	stdWriterFdIndex   = 0
+	stdWriterSizeIndex = 4
+
+	startingBufLen = 32*1024 + stdWriterPrefixLen + 1
+)
+
+// StdCopyWriter wraps a single io.Writer and frames every Write made
+// through one of its typed sub-writers with the 8-byte header used by
+// Docker's attach/logs protocol: {stream_type, 0, 0, 0, size_uint32_be}.
+// This lets stdout and stderr (and system errors) share one transport,
+// such as a hijacked HTTP connection, without a TTY.
+type StdCopyWriter struct {
+	dst io.Writer
+}
+
+// NewStdCopyWriter wraps dst for multiplexed writes.
+func NewStdCopyWriter(dst io.Writer) *StdCopyWriter {
+	return &StdCopyWriter{dst: dst}
+}
+
+// Stdout returns a writer that frames its writes as stdout.
+func (w *StdCopyWriter) Stdout() io.Writer {
+	return &stdWriter{dst: w.dst, stream: Stdout}
+}
+
+// Stderr returns a writer that frames its writes as stderr.
+func (w *StdCopyWriter) Stderr() io.Writer {
+	return &stdWriter{dst: w.dst, stream: Stderr}
+}
+
+// Systemerr returns a writer that frames its writes as a system error.
+func (w *StdCopyWriter) Systemerr() io.Writer {
+	return &stdWriter{dst: w.dst, stream: Systemerr}
+}
+
+// stdWriter frames every Write with the multiplexed stream header before
+// forwarding it to dst.
+type stdWriter struct {
+	dst    io.Writer
+	stream StdType
+}
+
+// Write implements io.Writer. It prefixes p with the 8-byte frame header
+// and writes header and payload as a single underlying Write so frames
+// are never interleaved by concurrent writers sharing dst.
+func (w *stdWriter) Write(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	header := [stdWriterPrefixLen]byte{stdWriterFdIndex: byte(w.stream)}
+	binary.BigEndian.PutUint32(header[stdWriterSizeIndex:], uint32(len(p)))
+
+	buf := make([]byte, 0, len(header)+len(p))
+	buf = append(buf, header[:]...)
+	buf = append(buf, p...)
+
+	if _, err := w.dst.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// StdCopy demultiplexes a stream produced by StdCopyWriter, reading from
+// src and writing each frame's payload to dstout or dsterr according to
+// its stream type. It reads until src returns EOF, returning any
+// framing or short-read error encountered along the way, and returns the
+// total number of payload bytes written.
+func StdCopy(dstout, dsterr io.Writer, src io.Reader) (written int64, err error) {
+	header := make([]byte, stdWriterPrefixLen)
+	var buf []byte
+
+	for {
+		if _, err := io.ReadFull(src, header); err != nil {
+			if err == io.EOF {
+				return written, nil
+			}
+			return written, err
+		}
+
+		stream := StdType(header[stdWriterFdIndex])
+		size := binary.BigEndian.Uint32(header[stdWriterSizeIndex:])
+
+		var dst io.Writer
+		switch stream {
+		case Stdout:
+			dst = dstout
+		case Stderr:
+			dst = dsterr
+		case Systemerr:
+			return written, fmt.Errorf("error from daemon in stream: %s", readAll(src, int(size)))
+		default:
+			return written, fmt.Errorf("unrecognized input header: %d", header)
+		}
+
+		if cap(buf) < int(size) {
+			bufCap := startingBufLen
+			if int(size) > bufCap {
+				bufCap = int(size)
+			}
+			buf = make([]byte, size, bufCap)
+		}
+		buf = buf[:size]
+
+		if _, err := io.ReadFull(src, buf); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return written, err
+		}
+
+		if dst == nil {
+			continue
+		}
+		n, err := dst.Write(buf)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+func readAll(r io.Reader, size int) string {
+	buf := make([]byte, size)
+	n, _ := io.ReadFull(r, buf)
+	return string(buf[:n])
+}
+
+// AttachMultiplexed subscribes a pair of StdCopyWriter sub-writers for
+// stdout and stderr to the existing broadcasters, so that attaching over
+// w (typically a single hijacked HTTP connection) carries both streams
+// without a TTY.
+func (c *Config) AttachMultiplexed(w io.Writer) {
+	mux := NewStdCopyWriter(w)
+	c.stdout.Add(nopCloseWriter{mux.Stdout()})
+	c.stderr.Add(nopCloseWriter{mux.Stderr()})
+}
+
+// nopCloseWriter adapts an io.Writer to io.WriteCloser for broadcasters
+// that require subscribers to be closeable.
+type nopCloseWriter struct {
+	io.Writer
+}
+
+func (nopCloseWriter) Close() error { return nil }
@@ -0,0 +1,92 @@
+package stream
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestStdCopyRoundTrip(t *testing.T) {
+	var framed bytes.Buffer
+	mux := NewStdCopyWriter(&framed)
+
+	if _, err := mux.Stdout().Write([]byte("hello stdout")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := mux.Stderr().Write([]byte("oops stderr")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var gotStdout, gotStderr bytes.Buffer
+	written, err := StdCopy(&gotStdout, &gotStderr, &framed)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := int64(len("hello stdout") + len("oops stderr")); written != want {
+		t.Fatalf("expected %d bytes written, got %d", want, written)
+	}
+	if gotStdout.String() != "hello stdout" {
+		t.Fatalf("expected stdout %q, got %q", "hello stdout", gotStdout.String())
+	}
+	if gotStderr.String() != "oops stderr" {
+		t.Fatalf("expected stderr %q, got %q", "oops stderr", gotStderr.String())
+	}
+}
+
+func TestStdCopyEmptyWritesAreNoFrames(t *testing.T) {
+	var framed bytes.Buffer
+	mux := NewStdCopyWriter(&framed)
+
+	if _, err := mux.Stdout().Write(nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if framed.Len() != 0 {
+		t.Fatalf("expected no frame to be written for an empty payload, got %d bytes", framed.Len())
+	}
+}
+
+func TestStdCopyShortFrameIsUnexpectedEOF(t *testing.T) {
+	var framed bytes.Buffer
+	mux := NewStdCopyWriter(&framed)
+	if _, err := mux.Stdout().Write([]byte("truncated")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	truncated := bytes.NewReader(framed.Bytes()[:framed.Len()-3])
+
+	var gotStdout, gotStderr bytes.Buffer
+	if _, err := StdCopy(&gotStdout, &gotStderr, truncated); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected io.ErrUnexpectedEOF for a truncated frame, got %v", err)
+	}
+}
+
+func TestStdCopyFrameLargerThanStartingBuffer(t *testing.T) {
+	var framed bytes.Buffer
+	mux := NewStdCopyWriter(&framed)
+
+	payload := bytes.Repeat([]byte("a"), startingBufLen+4096)
+	if _, err := mux.Stdout().Write(payload); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var gotStdout, gotStderr bytes.Buffer
+	written, err := StdCopy(&gotStdout, &gotStderr, &framed)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if written != int64(len(payload)) {
+		t.Fatalf("expected %d bytes written, got %d", len(payload), written)
+	}
+	if !bytes.Equal(gotStdout.Bytes(), payload) {
+		t.Fatal("stdout payload did not round-trip for a frame larger than startingBufLen")
+	}
+}
+
+func TestStdCopyUnrecognizedStreamType(t *testing.T) {
+	frame := []byte{0xff, 0, 0, 0, 0, 0, 0, 0}
+
+	var gotStdout, gotStderr bytes.Buffer
+	if _, err := StdCopy(&gotStdout, &gotStderr, bytes.NewReader(frame)); err == nil {
+		t.Fatal("expected an error for an unrecognized stream type, got nil")
+	}
+}
@@ -4,12 +4,12 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"regexp"
 	"strings"
 	"sync"
 
 	"github.com/containerd/containerd/cio"
 	"github.com/containerd/containerd/log"
+	"github.com/docker/docker/container/stream/toxics"
 	"github.com/docker/docker/pkg/broadcaster"
 	"github.com/docker/docker/pkg/ioutils"
 	"github.com/docker/docker/pkg/pools"
@@ -31,14 +31,49 @@ type Config struct {
 	stdin     io.ReadCloser
 	stdinPipe io.WriteCloser
 	dio       *cio.DirectIO
+
+	// stdoutFilters, stderrFilters and stdinFilters are applied to data
+	// as it is copied to/from the container's I/O streams in
+	// CopyToPipe. They are empty (no-op) by default; set them via
+	// SetStdoutFilters/SetStderrFilters/SetStdinFilters before
+	// CopyToPipe is called. Wiring these up from the container's
+	// HostConfig (e.g. StreamFilters) and the Engine API is daemon/API
+	// work that lives outside this package and isn't part of this
+	// change.
+	stdoutFilters []Transformation
+	stderrFilters []Transformation
+	stdinFilters  []Transformation
+
+	// detachKeys is the byte sequence that, when read from stdin,
+	// detaches the session. See SetDetachKeys and DetachNotify.
+	// detachNotify is allocated once in NewConfig so DetachNotify can be
+	// called safely from any goroutine, before or after CopyToPipe.
+	detachKeys   []byte
+	detachNotify chan error
+
+	// boundedStdout and boundedStderr are lazily created the first time
+	// StdoutPipeWithPolicy / StderrPipeWithPolicy is called, and
+	// subscribed to c.stdout / c.stderr so their subscribers receive the
+	// same data as any plain StdoutPipe/StderrPipe consumer.
+	boundedMu     sync.Mutex
+	boundedStdout *broadcaster.Bounded
+	boundedStderr *broadcaster.Bounded
+
+	// stdoutToxics, stderrToxics and stdinToxics are fault-injection
+	// chains applied ahead of the Transformation filters in CopyToPipe.
+	// See AddStdoutToxic / AddStderrToxic / AddStdinToxic.
+	stdoutToxics []toxics.Toxic
+	stderrToxics []toxics.Toxic
+	stdinToxics  []toxics.Toxic
 }
 
 // NewConfig creates a stream config and initializes
 // the standard err and standard out to new unbuffered broadcasters.
 func NewConfig() *Config {
 	return &Config{
-		stderr: new(broadcaster.Unbuffered),
-		stdout: new(broadcaster.Unbuffered),
+		stderr:       new(broadcaster.Unbuffered),
+		stdout:       new(broadcaster.Unbuffered),
+		detachNotify: make(chan error, 1),
 	}
 }
 
@@ -80,6 +115,80 @@ func (c *Config) StderrPipe() io.ReadCloser {
 	return bytesPipe
 }
 
+// StdoutPipeWithPolicy is like StdoutPipe, but the returned pipe is
+// subscribed with a backpressure policy: a slow reader has its queued
+// bytes dropped, spilled to disk, or is disconnected according to opts,
+// instead of blocking the container's stdout.
+func (c *Config) StdoutPipeWithPolicy(opts broadcaster.SubscriberOptions) (io.ReadCloser, error) {
+	return c.pipeWithPolicy(&c.boundedStdout, c.stdout, opts)
+}
+
+// StderrPipeWithPolicy is the Stderr counterpart of StdoutPipeWithPolicy.
+func (c *Config) StderrPipeWithPolicy(opts broadcaster.SubscriberOptions) (io.ReadCloser, error) {
+	return c.pipeWithPolicy(&c.boundedStderr, c.stderr, opts)
+}
+
+func (c *Config) pipeWithPolicy(bounded **broadcaster.Bounded, parent *broadcaster.Unbuffered, opts broadcaster.SubscriberOptions) (io.ReadCloser, error) {
+	c.boundedMu.Lock()
+	if *bounded == nil {
+		*bounded = broadcaster.NewBounded()
+		parent.Add(*bounded)
+	}
+	b := *bounded
+	c.boundedMu.Unlock()
+
+	bytesPipe := ioutils.NewBytesPipe()
+	if err := b.Subscribe(bytesPipe, opts); err != nil {
+		return nil, err
+	}
+	return bytesPipe, nil
+}
+
+// StreamStats reports backpressure counters for bounded stdout/stderr
+// subscribers, keyed by stream.
+type StreamStats struct {
+	Stdout []broadcaster.SubscriberStats
+	Stderr []broadcaster.SubscriberStats
+}
+
+// Stats returns the current backpressure counters for every subscriber
+// attached via StdoutPipeWithPolicy / StderrPipeWithPolicy. A stream on
+// which StdoutPipeWithPolicy / StderrPipeWithPolicy was never called
+// reports a nil slice; one whose subscribers have all been removed
+// reports an empty slice.
+func (c *Config) Stats() StreamStats {
+	c.boundedMu.Lock()
+	defer c.boundedMu.Unlock()
+
+	var s StreamStats
+	if c.boundedStdout != nil {
+		s.Stdout = c.boundedStdout.Stats()
+	}
+	if c.boundedStderr != nil {
+		s.Stderr = c.boundedStderr.Stats()
+	}
+	return s
+}
+
+// SetStdoutFilters configures the chain of Transformations applied to
+// data copied out over Stdout. It must be called before CopyToPipe.
+func (c *Config) SetStdoutFilters(t []Transformation) {
+	c.stdoutFilters = t
+}
+
+// SetStderrFilters configures the chain of Transformations applied to
+// data copied out over Stderr. It must be called before CopyToPipe.
+func (c *Config) SetStderrFilters(t []Transformation) {
+	c.stderrFilters = t
+}
+
+// SetStdinFilters configures the chain of Transformations applied to
+// data copied into the container's stdin. It must be called before
+// CopyToPipe.
+func (c *Config) SetStdinFilters(t []Transformation) {
+	c.stdinFilters = t
+}
+
 // NewInputPipes creates new pipes for both standard inputs, Stdin and StdinPipe.
 func (c *Config) NewInputPipes() {
 	c.stdin, c.stdinPipe = io.Pipe()
@@ -115,85 +224,81 @@ func (c *Config) CloseStreams() error {
 	return nil
 }
 
-type Transformation struct {
-	Pattern     *regexp.Regexp
-	Replacement string
-}
-
-func applyTransformations(s string, transformations []Transformation) string {
-	for _, t := range transformations {
-		s = t.Pattern.ReplaceAllString(s, t.Replacement)
-	}
-	return s
-}
-
-// TransformWriter wraps an io.Writer and applies transformations to the data being written.
-type TransformWriter struct {
-	w               io.Writer
-	transformations []Transformation
-	buffer          []byte
-}
-
-func max(a, b int) int {
-	if a > b {
-		return a
-	}
-	return b
-}
-
-func (tw *TransformWriter) Write(p []byte) (n int, err error) {
-	// Append the previous buffer to the current payload
-	payload := append(tw.buffer, p...)
-
-	transformed := applyTransformations(string(payload), tw.transformations)
-
-	// Store the last few bytes to the buffer for the next Write call
-	tw.buffer = payload[max(0, len(payload)-maxTransformLength):]
-
-	n, err = tw.w.Write([]byte(transformed))
-	return n - len(tw.buffer), err
-}
-
-const maxTransformLength = 100 // Adjust based on the maximum expected length of a transformation pattern
-
+// CopyToPipe connects streamConfig with a libcontainerd.IOPipe.
 func (c *Config) CopyToPipe(iop *cio.DirectIO) {
 	ctx := context.TODO()
 
 	c.dio = iop
-	copyFunc := func(w io.Writer, r io.ReadCloser, transformations []Transformation) {
-		tw := &TransformWriter{w: w, transformations: transformations}
+	copyFunc := func(w io.Writer, r io.ReadCloser, transformations []Transformation, tx []toxics.Toxic) {
+		tw := NewTransformWriter(w, transformations)
+		src := withToxics(ctx, r, tx)
 		c.wg.Add(1)
 		go func() {
-			if _, err := pools.Copy(tw, r); err != nil {
+			if _, err := pools.Copy(tw, src); err != nil {
 				log.G(ctx).Errorf("stream copy error: %v", err)
 			}
+			if err := tw.Flush(); err != nil {
+				log.G(ctx).Errorf("stream flush error: %v", err)
+			}
 			r.Close()
 			c.wg.Done()
 		}()
 	}
 
-	stdoutTransforms := []Transformation{
-		{Pattern: regexp.MustCompile("{black}"), Replacement: "{white}"},
-	}
-	stderrTransforms := []Transformation{
-		{Pattern: regexp.MustCompile("{red}"), Replacement: "{grn}"},
-	}
-	// stdinTransforms := []Transformation{
-	// 	{Pattern: regexp.MustCompile("orange"), Replacement: "blue"},
-	// }
-
 	if iop.Stdout != nil {
-		copyFunc(c.Stdout(), iop.Stdout, stdoutTransforms)
+		copyFunc(c.Stdout(), iop.Stdout, c.stdoutFilters, c.stdoutToxics)
 	}
 	if iop.Stderr != nil {
-		copyFunc(c.Stderr(), iop.Stderr, stderrTransforms)
+		copyFunc(c.Stderr(), iop.Stderr, c.stderrFilters, c.stderrToxics)
 	}
 	if stdin := c.Stdin(); stdin != nil {
 		if iop.Stdin != nil {
 			go func() {
-				// tw := &TransformWriter{w: iop.Stdin, transformations: stdinTransforms}
-				// pools.Copy(tw, stdin)
-				pools.Copy(iop.Stdin, stdin)
+				tw := NewTransformWriter(iop.Stdin, c.stdinFilters)
+
+				// Toxics run ahead of tw, on their own goroutine fed
+				// through an io.Pipe. The pipe is unbuffered, so a toxic
+				// that delays its reads also delays the detach-key scan
+				// below.
+				var dst io.Writer = tw
+				var pw *io.PipeWriter
+				toxicErr := make(chan error, 1)
+				if len(c.stdinToxics) > 0 {
+					var pr *io.PipeReader
+					pr, pw = io.Pipe()
+					dst = pw
+					go func() {
+						toxicErr <- toxics.Chain(ctx, tw, pr, c.stdinToxics...)
+					}()
+				} else {
+					toxicErr <- nil
+				}
+
+				detached := false
+				onDetach := func() error {
+					detached = true
+					if pw != nil {
+						pw.Close()
+					}
+					err := iop.Stdin.Close()
+					c.detachNotify <- DetachError{}
+					return err
+				}
+				if err := c.detachCopy(dst, stdin, onDetach); err != nil {
+					log.G(ctx).Errorf("stream copy error: %v", err)
+				}
+				if pw != nil && !detached {
+					pw.Close()
+				}
+				if err := <-toxicErr; err != nil {
+					log.G(ctx).Errorf("stream copy error: %v", err)
+				}
+				if detached {
+					return
+				}
+				if err := tw.Flush(); err != nil {
+					log.G(ctx).Errorf("stream flush error: %v", err)
+				}
 				if err := iop.Stdin.Close(); err != nil {
 					log.G(ctx).Warnf("failed to close stdin: %v", err)
 				}
@@ -2,6 +2,7 @@ package stream
 
 import (
 	"bytes"
+	"regexp"
 	"testing"
 )
 
@@ -18,8 +19,8 @@ func TestTransformWriter_Write(t *testing.T) {
 				"The black cat is on the red mat.",
 			},
 			transformations: []Transformation{
-				{Search: []byte("black"), Replacement: []byte("white")},
-				{Search: []byte("red"), Replacement: []byte("green")},
+				LiteralTransform{Search: []byte("black"), Replacement: []byte("white")},
+				LiteralTransform{Search: []byte("red"), Replacement: []byte("green")},
 			},
 			expectedOutput: []string{
 				"The white cat is on the green mat.",
@@ -32,8 +33,8 @@ func TestTransformWriter_Write(t *testing.T) {
 				"The red cat is on the black mat.",
 			},
 			transformations: []Transformation{
-				{Search: []byte("black"), Replacement: []byte("white")},
-				{Search: []byte("red"), Replacement: []byte("green")},
+				LiteralTransform{Search: []byte("black"), Replacement: []byte("white")},
+				LiteralTransform{Search: []byte("red"), Replacement: []byte("green")},
 			},
 			expectedOutput: []string{
 				"The white cat is on the green mat.",
@@ -45,16 +46,15 @@ func TestTransformWriter_Write(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			var buf bytes.Buffer
-			tw := &TransformWriter{
-				w:               &buf,
-				transformations: tt.transformations,
-			}
+			tw := NewTransformWriter(&buf, tt.transformations)
 
 			for i, chunk := range tt.input {
-				_, err := tw.Write([]byte(chunk))
-				if err != nil {
+				if _, err := tw.Write([]byte(chunk)); err != nil {
 					t.Fatalf("unexpected error: %v", err)
 				}
+				if err := tw.Flush(); err != nil {
+					t.Fatalf("unexpected flush error: %v", err)
+				}
 
 				if buf.String() != tt.expectedOutput[i] {
 					t.Fatalf("expected %q but got %q", tt.expectedOutput[i], buf.String())
@@ -64,3 +64,125 @@ func TestTransformWriter_Write(t *testing.T) {
 		})
 	}
 }
+
+// TestTransformWriter_WriteReturnsOriginalByteCount asserts that n from
+// Write always reflects bytes of the original input consumed, not the
+// length of the (possibly shorter or longer) transformed output.
+func TestTransformWriter_WriteReturnsOriginalByteCount(t *testing.T) {
+	tests := []struct {
+		name            string
+		input           string
+		transformations []Transformation
+	}{
+		{
+			name:            "no transformations",
+			input:           "hello world",
+			transformations: nil,
+		},
+		{
+			name:  "replacement shorter than match",
+			input: "aaaaaaaaaa",
+			transformations: []Transformation{
+				LiteralTransform{Search: []byte("aaaaaaaaaa"), Replacement: []byte("a")},
+			},
+		},
+		{
+			name:  "replacement longer than match",
+			input: "x",
+			transformations: []Transformation{
+				LiteralTransform{Search: []byte("x"), Replacement: []byte("xxxxxxxxxx")},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tw := NewTransformWriter(&buf, tt.transformations)
+
+			n, err := tw.Write([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if n != len(tt.input) {
+				t.Fatalf("expected n=%d (len of input), got %d", len(tt.input), n)
+			}
+		})
+	}
+}
+
+// TestTransformWriter_BoundarySplit asserts that a match spanning two
+// Write calls is still caught, and that nothing is flushed or
+// double-written before the match is resolved.
+func TestTransformWriter_BoundarySplit(t *testing.T) {
+	var buf bytes.Buffer
+	tw := NewTransformWriter(&buf, []Transformation{
+		LiteralTransform{Search: []byte("{black}"), Replacement: []byte("{white}")},
+	})
+
+	// Split the search term across two writes, both within the
+	// boundary buffer window.
+	if _, err := tw.Write([]byte("cat is {bla")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := tw.Write([]byte("ck} today")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := tw.Flush(); err != nil {
+		t.Fatalf("unexpected flush error: %v", err)
+	}
+
+	if want := "cat is {white} today"; buf.String() != want {
+		t.Fatalf("expected %q but got %q", want, buf.String())
+	}
+}
+
+// TestTransformWriter_BoundarySplitManyChunks feeds the same input one
+// byte at a time to make sure the boundary buffer behaves the same
+// regardless of chunk size.
+func TestTransformWriter_BoundarySplitManyChunks(t *testing.T) {
+	var buf bytes.Buffer
+	tw := NewTransformWriter(&buf, []Transformation{
+		RegexpTransform{Pattern: regexp.MustCompile(`\{red\}`), Replacement: []byte("{grn}")},
+	})
+
+	input := "warning: {red} alert triggered"
+	for i := 0; i < len(input); i++ {
+		if _, err := tw.Write([]byte{input[i]}); err != nil {
+			t.Fatalf("unexpected error at byte %d: %v", i, err)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		t.Fatalf("unexpected flush error: %v", err)
+	}
+
+	if want := "warning: {grn} alert triggered"; buf.String() != want {
+		t.Fatalf("expected %q but got %q", want, buf.String())
+	}
+}
+
+// TestTransformWriter_NoDoubleWrite asserts that bytes held back for
+// boundary matching are written exactly once, not re-emitted on the
+// following Write.
+func TestTransformWriter_NoDoubleWrite(t *testing.T) {
+	var buf bytes.Buffer
+	tw := NewTransformWriter(&buf, nil)
+
+	input := "abcdefghijklmnopqrstuvwxyz"
+	for i := 0; i < len(input); i += 3 {
+		end := i + 3
+		if end > len(input) {
+			end = len(input)
+		}
+		if _, err := tw.Write([]byte(input[i:end])); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		t.Fatalf("unexpected flush error: %v", err)
+	}
+
+	if buf.String() != input {
+		t.Fatalf("expected %q but got %q", input, buf.String())
+	}
+}
@@ -0,0 +1,65 @@
+package toxics // import "github.com/docker/docker/container/stream/toxics"
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Spec is the JSON-serializable description of a single Toxic, as
+// decoded by Load. This package only parses Specs; it does not accept
+// them over the Engine API or attach them to a container.
+type Spec struct {
+	Type string `json:"type"`
+
+	MeanMS        int     `json:"mean_ms,omitempty"`
+	JitterMS      int     `json:"jitter_ms,omitempty"`
+	KBPS          int     `json:"kbps,omitempty"`
+	AvgSize       int     `json:"avg_size,omitempty"`
+	SizeVariation int     `json:"size_variation,omitempty"`
+	DelayMS       int     `json:"delay_ms,omitempty"`
+	Bytes         int64   `json:"bytes,omitempty"`
+	Probability   float64 `json:"probability,omitempty"`
+}
+
+// Load parses a JSON array of Specs into concrete Toxics, in order.
+func Load(data []byte) ([]Toxic, error) {
+	var specs []Spec
+	if err := json.Unmarshal(data, &specs); err != nil {
+		return nil, fmt.Errorf("toxics: decoding spec: %w", err)
+	}
+
+	result := make([]Toxic, 0, len(specs))
+	for _, s := range specs {
+		t, err := s.toxic()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, t)
+	}
+	return result, nil
+}
+
+func (s Spec) toxic() (Toxic, error) {
+	switch s.Type {
+	case "latency":
+		return Latency{
+			Mean:   time.Duration(s.MeanMS) * time.Millisecond,
+			Jitter: time.Duration(s.JitterMS) * time.Millisecond,
+		}, nil
+	case "bandwidth":
+		return Bandwidth{KBPS: s.KBPS}, nil
+	case "slicer":
+		return Slicer{
+			AvgSize:       s.AvgSize,
+			SizeVariation: s.SizeVariation,
+			Delay:         time.Duration(s.DelayMS) * time.Millisecond,
+		}, nil
+	case "limit_data":
+		return LimitData{Bytes: s.Bytes}, nil
+	case "bitrot":
+		return Bitrot{Probability: s.Probability}, nil
+	default:
+		return nil, fmt.Errorf("toxics: unknown toxic type %q", s.Type)
+	}
+}
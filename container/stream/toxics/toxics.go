@@ -0,0 +1,252 @@
+// Package toxics lets tests and users inject controlled faults into a
+// container/stream.Config's copy pipeline, without patching the daemon.
+// It borrows its vocabulary from Shopify's toxiproxy: each Toxic shapes
+// or corrupts a stream of bytes in a specific way, so flaky-network
+// scenarios (dropped connections, slow links, bit errors) can be
+// exercised in CI. Latency jitter, Slicer piece sizes and Bitrot draw
+// from the global math/rand source, so their exact effect varies from
+// run to run.
+package toxics // import "github.com/docker/docker/container/stream/toxics"
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"time"
+)
+
+// Toxic injects a fault while copying src to dst. Implementations must
+// return once src is exhausted (io.EOF, reported as nil), ctx is done,
+// or an unrecoverable read/write error occurs.
+type Toxic interface {
+	Pipe(ctx context.Context, dst io.Writer, src io.Reader) error
+}
+
+// Chain composes toxics so each one's output feeds the next one's
+// input, with the last toxic writing to dst. With no toxics, Chain is a
+// plain io.Copy.
+func Chain(ctx context.Context, dst io.Writer, src io.Reader, toxics ...Toxic) error {
+	switch len(toxics) {
+	case 0:
+		_, err := io.Copy(dst, src)
+		return err
+	case 1:
+		return toxics[0].Pipe(ctx, dst, src)
+	}
+
+	r, w := io.Pipe()
+	stageErr := make(chan error, 1)
+	go func() {
+		stageErr <- toxics[0].Pipe(ctx, w, src)
+		w.Close()
+	}()
+
+	err := Chain(ctx, dst, r, toxics[1:]...)
+	if first := <-stageErr; err == nil {
+		err = first
+	}
+	return err
+}
+
+const defaultBufSize = 32 * 1024
+
+// sleep blocks for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Latency delays every chunk it forwards by Mean, plus or minus a
+// uniformly distributed Jitter.
+type Latency struct {
+	Mean   time.Duration
+	Jitter time.Duration
+}
+
+// Pipe implements Toxic.
+func (t Latency) Pipe(ctx context.Context, dst io.Writer, src io.Reader) error {
+	buf := make([]byte, defaultBufSize)
+	for {
+		n, rerr := src.Read(buf)
+		if n > 0 {
+			d := t.Mean
+			if t.Jitter > 0 {
+				d += time.Duration(rand.Int63n(2*int64(t.Jitter))) - t.Jitter
+				if d < 0 {
+					d = 0
+				}
+			}
+			if err := sleep(ctx, d); err != nil {
+				return err
+			}
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if rerr != nil {
+			if rerr == io.EOF {
+				return nil
+			}
+			return rerr
+		}
+	}
+}
+
+// Bandwidth throttles throughput to roughly KBPS kilobytes per second,
+// using a simple token-bucket: each chunk read is paced out based on
+// how long it "should" have taken to arrive at that rate.
+type Bandwidth struct {
+	KBPS int
+}
+
+// Pipe implements Toxic.
+func (t Bandwidth) Pipe(ctx context.Context, dst io.Writer, src io.Reader) error {
+	bytesPerSec := float64(t.KBPS) * 1024
+	buf := make([]byte, 4096)
+	for {
+		n, rerr := src.Read(buf)
+		if n > 0 {
+			if bytesPerSec > 0 {
+				d := time.Duration(float64(n) / bytesPerSec * float64(time.Second))
+				if err := sleep(ctx, d); err != nil {
+					return err
+				}
+			}
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if rerr != nil {
+			if rerr == io.EOF {
+				return nil
+			}
+			return rerr
+		}
+	}
+}
+
+// Slicer chops every chunk it reads into pieces of around AvgSize bytes
+// (uniformly varied by up to SizeVariation), writing each piece as its
+// own Write and pausing Delay between pieces. It is useful for
+// reproducing clients that assume reads line up with writes.
+type Slicer struct {
+	AvgSize       int
+	SizeVariation int
+	Delay         time.Duration
+}
+
+// Pipe implements Toxic.
+func (t Slicer) Pipe(ctx context.Context, dst io.Writer, src io.Reader) error {
+	buf := make([]byte, defaultBufSize)
+	for {
+		n, rerr := src.Read(buf)
+		if n > 0 {
+			data := buf[:n]
+			for len(data) > 0 {
+				size := t.AvgSize
+				if t.SizeVariation > 0 {
+					size += rand.Intn(2*t.SizeVariation+1) - t.SizeVariation
+				}
+				if size < 1 {
+					size = 1
+				}
+				if size > len(data) {
+					size = len(data)
+				}
+				if _, err := dst.Write(data[:size]); err != nil {
+					return err
+				}
+				data = data[size:]
+				if len(data) > 0 {
+					if err := sleep(ctx, t.Delay); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		if rerr != nil {
+			if rerr == io.EOF {
+				return nil
+			}
+			return rerr
+		}
+	}
+}
+
+// LimitData forwards at most Bytes bytes and then stops, as if the
+// connection had been cut.
+type LimitData struct {
+	Bytes int64
+}
+
+// Pipe implements Toxic.
+func (t LimitData) Pipe(ctx context.Context, dst io.Writer, src io.Reader) error {
+	buf := make([]byte, defaultBufSize)
+	var sent int64
+	for sent < t.Bytes {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		want := int64(len(buf))
+		if remaining := t.Bytes - sent; remaining < want {
+			want = remaining
+		}
+		n, rerr := src.Read(buf[:want])
+		if n > 0 {
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return err
+			}
+			sent += int64(n)
+		}
+		if rerr != nil {
+			if rerr == io.EOF {
+				return nil
+			}
+			return rerr
+		}
+	}
+	return nil
+}
+
+// Bitrot flips a random bit in each byte with the given Probability
+// (0 to 1), simulating data corruption on the wire.
+type Bitrot struct {
+	Probability float64
+}
+
+// Pipe implements Toxic.
+func (t Bitrot) Pipe(ctx context.Context, dst io.Writer, src io.Reader) error {
+	buf := make([]byte, defaultBufSize)
+	for {
+		n, rerr := src.Read(buf)
+		if n > 0 {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			chunk := append([]byte(nil), buf[:n]...)
+			for i := range chunk {
+				if rand.Float64() < t.Probability {
+					chunk[i] ^= 1 << uint(rand.Intn(8))
+				}
+			}
+			if _, err := dst.Write(chunk); err != nil {
+				return err
+			}
+		}
+		if rerr != nil {
+			if rerr == io.EOF {
+				return nil
+			}
+			return rerr
+		}
+	}
+}
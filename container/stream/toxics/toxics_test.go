@@ -0,0 +1,145 @@
+package toxics
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatencyPreservesData(t *testing.T) {
+	var dst bytes.Buffer
+	tx := Latency{Mean: time.Millisecond, Jitter: time.Millisecond}
+
+	start := time.Now()
+	if err := tx.Pipe(context.Background(), &dst, strings.NewReader("hello toxic world")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed <= 0 {
+		t.Fatalf("expected Pipe to take non-zero time, took %s", elapsed)
+	}
+	if dst.String() != "hello toxic world" {
+		t.Fatalf("expected data to pass through unmodified, got %q", dst.String())
+	}
+}
+
+func TestBandwidthPreservesData(t *testing.T) {
+	var dst bytes.Buffer
+	tx := Bandwidth{KBPS: 1024 * 1024} // fast enough not to slow the test down
+	if err := tx.Pipe(context.Background(), &dst, strings.NewReader("some data to throttle")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dst.String() != "some data to throttle" {
+		t.Fatalf("expected data to pass through unmodified, got %q", dst.String())
+	}
+}
+
+func TestSlicerPreservesDataAcrossManySmallWrites(t *testing.T) {
+	var dst bytes.Buffer
+	recorder := &writeCounter{w: &dst}
+	tx := Slicer{AvgSize: 3, SizeVariation: 1}
+
+	input := "the quick brown fox jumps over the lazy dog"
+	if err := tx.Pipe(context.Background(), recorder, strings.NewReader(input)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dst.String() != input {
+		t.Fatalf("expected %q, got %q", input, dst.String())
+	}
+	if recorder.writes < 2 {
+		t.Fatalf("expected the slicer to split the input into multiple writes, got %d", recorder.writes)
+	}
+}
+
+func TestLimitDataCutsOffAtBytes(t *testing.T) {
+	var dst bytes.Buffer
+	tx := LimitData{Bytes: 5}
+
+	if err := tx.Pipe(context.Background(), &dst, strings.NewReader("hello world, this keeps going")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dst.String() != "hello" {
+		t.Fatalf("expected exactly 5 bytes %q, got %q", "hello", dst.String())
+	}
+}
+
+func TestBitrotCanCorruptData(t *testing.T) {
+	var dst bytes.Buffer
+	tx := Bitrot{Probability: 1} // flip every byte, deterministically observable
+
+	input := "aaaaaaaaaa"
+	if err := tx.Pipe(context.Background(), &dst, strings.NewReader(input)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dst.Len() != len(input) {
+		t.Fatalf("expected output length %d, got %d", len(input), dst.Len())
+	}
+	if dst.String() == input {
+		t.Fatal("expected Bitrot with probability 1 to corrupt every byte")
+	}
+}
+
+func TestBitrotZeroProbabilityLeavesDataUnchanged(t *testing.T) {
+	var dst bytes.Buffer
+	tx := Bitrot{Probability: 0}
+
+	input := "leave me alone"
+	if err := tx.Pipe(context.Background(), &dst, strings.NewReader(input)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dst.String() != input {
+		t.Fatalf("expected %q, got %q", input, dst.String())
+	}
+}
+
+func TestChainPassesDataThroughEveryToxic(t *testing.T) {
+	var dst bytes.Buffer
+	input := "chained data"
+
+	err := Chain(context.Background(), &dst, strings.NewReader(input),
+		LimitData{Bytes: int64(len(input))},
+		Latency{Mean: time.Millisecond},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dst.String() != input {
+		t.Fatalf("expected %q, got %q", input, dst.String())
+	}
+}
+
+func TestLoadParsesAllToxicTypes(t *testing.T) {
+	spec := []byte(`[
+		{"type": "latency", "mean_ms": 10, "jitter_ms": 2},
+		{"type": "bandwidth", "kbps": 512},
+		{"type": "slicer", "avg_size": 16, "size_variation": 4, "delay_ms": 1},
+		{"type": "limit_data", "bytes": 1024},
+		{"type": "bitrot", "probability": 0.01}
+	]`)
+
+	got, err := Load(spec)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 5 {
+		t.Fatalf("expected 5 toxics, got %d", len(got))
+	}
+}
+
+func TestLoadRejectsUnknownType(t *testing.T) {
+	_, err := Load([]byte(`[{"type": "teleport"}]`))
+	if err == nil {
+		t.Fatal("expected an error for an unknown toxic type")
+	}
+}
+
+type writeCounter struct {
+	w      *bytes.Buffer
+	writes int
+}
+
+func (w *writeCounter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.w.Write(p)
+}
@@ -0,0 +1,113 @@
+package stream // import "github.com/docker/docker/container/stream"
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+)
+
+// Transformation mutates a chunk of stream data before it reaches the
+// consumer. Implementations must tolerate being called repeatedly on
+// adjacent, boundary-split chunks of the same stream: TransformWriter
+// only ever hands them complete, non-overlapping slices.
+type Transformation interface {
+	Transform(p []byte) []byte
+}
+
+// RegexpTransform replaces every match of Pattern with Replacement,
+// following the semantics of (*regexp.Regexp).ReplaceAll.
+type RegexpTransform struct {
+	Pattern     *regexp.Regexp
+	Replacement []byte
+}
+
+// Transform implements Transformation.
+func (t RegexpTransform) Transform(p []byte) []byte {
+	return t.Pattern.ReplaceAll(p, t.Replacement)
+}
+
+// LiteralTransform replaces every occurrence of Search with Replacement.
+// It is cheaper than RegexpTransform for plain substitutions.
+type LiteralTransform struct {
+	Search      []byte
+	Replacement []byte
+}
+
+// Transform implements Transformation.
+func (t LiteralTransform) Transform(p []byte) []byte {
+	return bytes.ReplaceAll(p, t.Search, t.Replacement)
+}
+
+// FuncTransform adapts a plain function to the Transformation interface.
+type FuncTransform func(p []byte) []byte
+
+// Transform implements Transformation.
+func (f FuncTransform) Transform(p []byte) []byte {
+	return f(p)
+}
+
+func applyTransformations(p []byte, transformations []Transformation) []byte {
+	for _, t := range transformations {
+		p = t.Transform(p)
+	}
+	return p
+}
+
+// maxTransformLength is the longest match any Transformation in this
+// package is expected to span. TransformWriter holds back at least this
+// many trailing bytes of every Write so a match straddling two Writes is
+// still caught.
+const maxTransformLength = 100
+
+// TransformWriter wraps an io.Writer and applies a chain of
+// Transformations to the data before it is written through. Because a
+// match may straddle two Write calls, TransformWriter holds back the
+// trailing maxTransformLength bytes of each call until more data (or a
+// Flush) resolves them.
+type TransformWriter struct {
+	w               io.Writer
+	transformations []Transformation
+	buffer          []byte
+}
+
+// NewTransformWriter wraps w, applying transformations to everything
+// written to the returned writer.
+func NewTransformWriter(w io.Writer, transformations []Transformation) *TransformWriter {
+	return &TransformWriter{w: w, transformations: transformations}
+}
+
+// Write applies tw's transformations to p and writes the result to the
+// underlying writer. The returned n reflects the number of bytes of p
+// consumed, not the length of the (possibly shorter or longer)
+// transformed output, so callers can treat TransformWriter like any
+// other io.Writer.
+func (tw *TransformWriter) Write(p []byte) (n int, err error) {
+	payload := append(tw.buffer, p...)
+
+	keep := maxTransformLength
+	if keep > len(payload) {
+		keep = len(payload)
+	}
+	flushable, hold := payload[:len(payload)-keep], payload[len(payload)-keep:]
+	tw.buffer = append([]byte(nil), hold...)
+
+	if len(flushable) > 0 {
+		if _, err := tw.w.Write(applyTransformations(flushable, tw.transformations)); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes out any bytes still held back for boundary matching. It
+// must be called once the source has been fully copied, or the last
+// maxTransformLength bytes of the stream are silently lost.
+func (tw *TransformWriter) Flush() error {
+	if len(tw.buffer) == 0 {
+		return nil
+	}
+	buffered := tw.buffer
+	tw.buffer = nil
+	_, err := tw.w.Write(applyTransformations(buffered, tw.transformations))
+	return err
+}
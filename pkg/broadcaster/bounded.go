@@ -0,0 +1,485 @@
+package broadcaster // import "github.com/docker/docker/pkg/broadcaster"
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// ErrSubscriberDisconnected is returned by a subscriber's Read once the
+// Disconnect backpressure policy has evicted it for exceeding its
+// high-water mark.
+var ErrSubscriberDisconnected = errors.New("broadcaster: subscriber disconnected after exceeding high-water mark")
+
+// BackpressurePolicy selects what a Bounded subscriber does when it
+// cannot keep up with the writer feeding the broadcaster.
+type BackpressurePolicy int
+
+const (
+	// DropOldest discards the oldest buffered bytes to make room for
+	// new ones, like a ring buffer.
+	DropOldest BackpressurePolicy = iota
+	// DropNewest discards incoming bytes once the buffer is full,
+	// preserving whatever was already queued.
+	DropNewest
+	// SpillToDisk overflows buffered bytes to a file once the in-memory
+	// buffer is full, and transparently reads them back in order.
+	SpillToDisk
+	// Disconnect removes the subscriber (closing it with
+	// ErrSubscriberDisconnected) once it exceeds its high-water mark.
+	Disconnect
+)
+
+// SubscriberOptions configures how a single Bounded subscriber absorbs
+// backpressure.
+type SubscriberOptions struct {
+	// Policy selects the backpressure strategy.
+	Policy BackpressurePolicy
+	// BufferBytes is the size of the in-memory ring buffer before Policy
+	// kicks in. Defaults to 64KB if zero.
+	BufferBytes int64
+	// SpillDir is the directory overflow is written to when Policy is
+	// SpillToDisk. Required for that policy.
+	SpillDir string
+	// SpillMaxBytes caps how many bytes may be pending in the spill
+	// file; incoming bytes that would exceed it are dropped and counted
+	// in BytesDropped. Zero means unbounded.
+	SpillMaxBytes int64
+}
+
+const defaultBufferBytes = 64 * 1024
+
+// SubscriberStats reports a single subscriber's backpressure counters.
+type SubscriberStats struct {
+	BytesDropped int64
+	BytesSpilled int64
+	HighWater    int64
+}
+
+// Bounded is a broadcaster whose subscribers each absorb backpressure
+// according to their own SubscriberOptions, so that one slow consumer
+// can never stall the Write call feeding the broadcaster.
+type Bounded struct {
+	mu          sync.Mutex
+	subscribers []*boundedSubscriber
+}
+
+// NewBounded returns an empty Bounded broadcaster.
+func NewBounded() *Bounded {
+	return &Bounded{}
+}
+
+// Subscribe registers dst to receive everything written to b from now
+// on, applying opts' backpressure policy. dst is closed once the
+// subscriber is removed; if dst has a CloseWithError(error) error method
+// and the removal has a cause (such as ErrSubscriberDisconnected), that
+// method is called with the cause instead of Close.
+func (b *Bounded) Subscribe(dst io.WriteCloser, opts SubscriberOptions) error {
+	if opts.BufferBytes <= 0 {
+		opts.BufferBytes = defaultBufferBytes
+	}
+
+	s := &boundedSubscriber{
+		parent: b,
+		dst:    dst,
+		opts:   opts,
+		cond:   sync.NewCond(&sync.Mutex{}),
+	}
+	if opts.Policy == SpillToDisk {
+		sf, err := newSpillFile(opts.SpillDir)
+		if err != nil {
+			return fmt.Errorf("broadcaster: preparing spill file: %w", err)
+		}
+		s.spill = sf
+	}
+
+	b.mu.Lock()
+	b.subscribers = append(b.subscribers, s)
+	b.mu.Unlock()
+
+	go s.drain()
+	return nil
+}
+
+// Write delivers p to every subscriber according to its backpressure
+// policy. It never blocks on a slow subscriber and always reports
+// len(p), nil, matching the broadcast semantics of Unbuffered.
+func (b *Bounded) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	live := b.subscribers[:0]
+	var evicted []*boundedSubscriber
+	for _, s := range b.subscribers {
+		if s.deliver(p) {
+			live = append(live, s)
+		} else {
+			evicted = append(evicted, s)
+		}
+	}
+	b.subscribers = live
+	b.mu.Unlock()
+
+	// Closing dst may itself block or take time; do it outside b.mu so
+	// it never delays the next Write to other subscribers. finish is
+	// idempotent, so it's harmless if drain races us to the same
+	// subscriber (e.g. a failed dst.Write) and gets there first.
+	for _, s := range evicted {
+		s.finish(ErrSubscriberDisconnected)
+	}
+	return len(p), nil
+}
+
+// remove splices target out of b.subscribers, if it's still there. It
+// is called by boundedSubscriber.finish so a subscriber that terminates
+// itself (a failed dst.Write observed by drain, or the Disconnect
+// policy) never lingers in Stats() or risks being finished twice.
+func (b *Bounded) remove(target *boundedSubscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, s := range b.subscribers {
+		if s == target {
+			b.subscribers = append(b.subscribers[:i], b.subscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+// Stats returns a snapshot of each live subscriber's counters, in
+// subscription order.
+func (b *Bounded) Stats() []SubscriberStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := make([]SubscriberStats, len(b.subscribers))
+	for i, s := range b.subscribers {
+		stats[i] = s.stats()
+	}
+	return stats
+}
+
+// Close implements io.Closer so a Bounded can itself be subscribed to
+// another broadcaster (e.g. Unbuffered.Add). It is equivalent to Clean.
+func (b *Bounded) Close() error {
+	return b.Clean()
+}
+
+// Clean closes and removes every current subscriber. Subscribers added
+// afterwards still receive later writes.
+func (b *Bounded) Clean() error {
+	b.mu.Lock()
+	subs := append([]*boundedSubscriber(nil), b.subscribers...)
+	b.mu.Unlock()
+
+	var errs []string
+	for _, s := range subs {
+		if err := s.finish(nil); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(joinErrs(errs))
+	}
+	return nil
+}
+
+func joinErrs(errs []string) string {
+	out := errs[0]
+	for _, e := range errs[1:] {
+		out += "\n" + e
+	}
+	return out
+}
+
+// boundedSubscriber queues writes in a ring buffer (optionally spilling
+// to disk) and drains them to dst on its own goroutine, so Bounded.Write
+// never blocks on dst.
+type boundedSubscriber struct {
+	parent *Bounded
+	dst    io.WriteCloser
+	opts   SubscriberOptions
+
+	cond      *sync.Cond
+	ring      []byte // unconsumed bytes queued for dst, FIFO
+	spill     *spillFile
+	closed    bool
+	evictErr  error
+	droppedB  int64
+	spilledB  int64
+	highWater int64
+
+	finishOnce sync.Once
+}
+
+func (s *boundedSubscriber) stats() SubscriberStats {
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+	return SubscriberStats{
+		BytesDropped: s.droppedB,
+		BytesSpilled: s.spilledB,
+		HighWater:    s.highWater,
+	}
+}
+
+// deliver queues p for dst without blocking the caller, applying the
+// subscriber's backpressure policy if its buffer is full. It reports
+// whether the subscriber is still live.
+func (s *boundedSubscriber) deliver(p []byte) bool {
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+
+	if s.closed {
+		return false
+	}
+
+	queued := int64(len(s.ring))
+	if s.spill != nil {
+		queued += s.spill.pending()
+	}
+	if queued > s.highWater {
+		s.highWater = queued
+	}
+
+	room := s.opts.BufferBytes - int64(len(s.ring))
+	if s.spill != nil && s.spill.pending() > 0 {
+		// drain empties the ring before the spill file, so once bytes
+		// are on disk everything newer must queue behind them there.
+		room = 0
+	}
+	if room >= int64(len(p)) {
+		s.ring = append(s.ring, p...)
+		s.cond.Signal()
+		return true
+	}
+
+	switch s.opts.Policy {
+	case DropNewest:
+		if room > 0 {
+			s.ring = append(s.ring, p[:room]...)
+		}
+		s.droppedB += int64(len(p)) - room
+	case DropOldest:
+		combined := append(s.ring, p...)
+		if over := int64(len(combined)) - s.opts.BufferBytes; over > 0 {
+			s.droppedB += over
+			combined = combined[over:]
+		}
+		s.ring = combined
+	case SpillToDisk:
+		if room > 0 {
+			s.ring = append(s.ring, p[:room]...)
+			p = p[room:]
+		}
+		if err := s.spill.write(p, s.opts.SpillMaxBytes); err != nil {
+			// Disk is unavailable or full: degrade to dropping rather
+			// than blocking the writer.
+			s.droppedB += int64(len(p))
+			break
+		}
+		s.spilledB += int64(len(p))
+	case Disconnect:
+		s.closed = true
+		s.evictErr = ErrSubscriberDisconnected
+		s.cond.Signal()
+		return false // s.finish is called by Write, outside the lock
+	}
+
+	s.cond.Signal()
+	return true
+}
+
+// drain runs on its own goroutine, writing queued bytes to dst as they
+// become available.
+func (s *boundedSubscriber) drain() {
+	for {
+		s.cond.L.Lock()
+		for len(s.ring) == 0 && !s.closed && (s.spill == nil || s.spill.pending() == 0) {
+			s.cond.Wait()
+		}
+		if s.closed && len(s.ring) == 0 && (s.spill == nil || s.spill.pending() == 0) {
+			s.cond.L.Unlock()
+			return
+		}
+
+		var chunk []byte
+		if len(s.ring) > 0 {
+			chunk = s.ring
+			s.ring = nil
+		} else if s.spill != nil {
+			chunk, _ = s.spill.readChunk()
+		}
+		s.cond.L.Unlock()
+
+		if len(chunk) == 0 {
+			continue
+		}
+		if _, err := s.dst.Write(chunk); err != nil {
+			s.finish(err)
+			return
+		}
+	}
+}
+
+// finish closes dst (and any spill file) and marks the subscriber
+// closed so drain exits and deliver stops queuing, however the
+// subscriber came to be evicted — a failed dst.Write observed by
+// drain, the Disconnect policy during deliver, or Bounded.Clean. It
+// also removes s from its parent's subscriber list, so Stats() stops
+// reporting it and a later caller can't double-close it.
+//
+// finish may race another call for the same subscriber (drain and
+// Bounded.Write can both decide to evict it around the same time); only
+// the first actually closes anything, the rest are no-ops.
+func (s *boundedSubscriber) finish(cause error) error {
+	var err error
+	s.finishOnce.Do(func() {
+		s.cond.L.Lock()
+		s.closed = true
+		if cause != nil {
+			s.evictErr = cause
+		}
+		s.cond.L.Unlock()
+		s.cond.Signal() // wake drain so it notices s.closed and exits
+
+		if s.spill != nil {
+			s.spill.close()
+		}
+		if ce, ok := s.dst.(interface{ CloseWithError(error) error }); ok && cause != nil {
+			err = ce.CloseWithError(cause)
+		} else {
+			err = s.dst.Close()
+		}
+	})
+	if s.parent != nil {
+		s.parent.remove(s)
+	}
+	return err
+}
+
+// spillCompactThreshold bounds how far s.read is allowed to drift from
+// the start of the file before spillFile reclaims the already-drained
+// prefix. Without this, written only ever grows: a long-lived
+// subscriber with an intermittently slow consumer would otherwise leave
+// an unlinked file whose on-disk size tracks total bytes ever spilled,
+// not the logical (bounded) backlog.
+const spillCompactThreshold = 1 << 20 // 1MiB
+
+// spillFile is an overflow file drained in the same order bytes were
+// written to it. Fully-drained bytes are reclaimed as they're read:
+// once the backlog empties out the file is truncated back to empty,
+// and if it doesn't empty out within spillCompactThreshold bytes read,
+// the unread tail is shifted to the front and the file truncated to
+// just that tail, so its on-disk size stays bounded by roughly
+// threshold + pending rather than growing for the subscriber's
+// lifetime.
+type spillFile struct {
+	mu      sync.Mutex
+	f       *os.File
+	written int64
+	read    int64
+}
+
+func newSpillFile(dir string) (*spillFile, error) {
+	if dir == "" {
+		return nil, errors.New("SpillDir must be set for the SpillToDisk policy")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(dir, "broadcaster-spill-*")
+	if err != nil {
+		return nil, err
+	}
+	// The file is unlinked immediately: its contents are only ever
+	// reached through this process's own fd, and the space is reclaimed
+	// as soon as the subscriber is evicted or the broadcaster cleans up.
+	_ = os.Remove(f.Name())
+	return &spillFile{f: f}, nil
+}
+
+func (s *spillFile) pending() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.written - s.read
+}
+
+func (s *spillFile) write(p []byte, maxBytes int64) error {
+	if len(p) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if maxBytes > 0 && s.written-s.read+int64(len(p)) > maxBytes {
+		return fmt.Errorf("spill file exceeds max bytes %d", maxBytes)
+	}
+	n, err := s.f.WriteAt(p, s.written)
+	s.written += int64(n)
+	return err
+}
+
+func (s *spillFile) readChunk() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.read >= s.written {
+		// Fully drained: reclaim the space instead of letting the file
+		// grow for as long as the subscriber lives.
+		s.reclaimLocked()
+		return nil, nil
+	}
+
+	size := s.written - s.read
+	const maxReadChunk = 32 * 1024
+	if size > maxReadChunk {
+		size = maxReadChunk
+	}
+	buf := make([]byte, size)
+	n, err := s.f.ReadAt(buf, s.read)
+	s.read += int64(n)
+
+	if s.read >= spillCompactThreshold {
+		s.reclaimLocked()
+	}
+
+	if err != nil && err != io.EOF {
+		return buf[:n], err
+	}
+	return buf[:n], nil
+}
+
+// reclaimLocked shrinks the file back down to just its unread tail,
+// either because that tail is empty (the common case: the consumer
+// caught up) or because s.read has drifted past spillCompactThreshold
+// and the tail is worth copy-compacting. Callers must hold s.mu.
+func (s *spillFile) reclaimLocked() {
+	pending := s.written - s.read
+	if pending <= 0 {
+		if s.written > 0 {
+			if err := s.f.Truncate(0); err == nil {
+				s.written, s.read = 0, 0
+			}
+		}
+		return
+	}
+	if s.read < spillCompactThreshold {
+		return
+	}
+
+	buf := make([]byte, pending)
+	if _, err := s.f.ReadAt(buf, s.read); err != nil {
+		return
+	}
+	if _, err := s.f.WriteAt(buf, 0); err != nil {
+		return
+	}
+	if err := s.f.Truncate(pending); err != nil {
+		return
+	}
+	s.written, s.read = pending, 0
+}
+
+func (s *spillFile) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.f.Close()
+}
@@ -0,0 +1,247 @@
+package broadcaster
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingWriter never returns from Write until release is closed, to
+// simulate a stalled consumer (e.g. a stuck docker logs -f client).
+type blockingWriter struct {
+	release chan struct{}
+	mu      sync.Mutex
+	written bytes.Buffer
+}
+
+func newBlockingWriter() *blockingWriter {
+	return &blockingWriter{release: make(chan struct{})}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.written.Write(p)
+	return len(p), nil
+}
+
+func (w *blockingWriter) Close() error { return nil }
+
+func writeWithTimeout(t *testing.T, b *Bounded, p []byte, timeout time.Duration) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		if _, err := b.Write(p); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		t.Fatalf("Write blocked for more than %s on a stalled subscriber", timeout)
+	}
+}
+
+func TestBoundedDropOldestDoesNotBlockWriter(t *testing.T) {
+	b := NewBounded()
+	slow := newBlockingWriter()
+	if err := b.Subscribe(slow, SubscriberOptions{Policy: DropOldest, BufferBytes: 8}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		writeWithTimeout(t, b, []byte("abcd"), time.Second)
+	}
+
+	stats := b.Stats()
+	if len(stats) != 1 {
+		t.Fatalf("expected 1 subscriber, got %d", len(stats))
+	}
+	if stats[0].BytesDropped == 0 {
+		t.Fatal("expected some bytes to have been dropped")
+	}
+}
+
+func TestBoundedDropNewestDoesNotBlockWriter(t *testing.T) {
+	b := NewBounded()
+	slow := newBlockingWriter()
+	if err := b.Subscribe(slow, SubscriberOptions{Policy: DropNewest, BufferBytes: 4}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	writeWithTimeout(t, b, []byte("ab"), time.Second)
+	writeWithTimeout(t, b, []byte("cdefgh"), time.Second)
+
+	stats := b.Stats()
+	if stats[0].BytesDropped == 0 {
+		t.Fatal("expected some bytes to have been dropped")
+	}
+}
+
+func TestBoundedDisconnectEvictsSubscriber(t *testing.T) {
+	b := NewBounded()
+	slow := newBlockingWriter()
+	if err := b.Subscribe(slow, SubscriberOptions{Policy: Disconnect, BufferBytes: 4}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	writeWithTimeout(t, b, []byte("abcd"), time.Second)
+	writeWithTimeout(t, b, []byte("overflow"), time.Second)
+
+	if len(b.subscribers) != 0 {
+		t.Fatalf("expected the subscriber to have been evicted, got %d remaining", len(b.subscribers))
+	}
+}
+
+func TestBoundedSpillToDiskDoesNotBlockWriterAndDrainsInOrder(t *testing.T) {
+	dir := t.TempDir()
+	b := NewBounded()
+
+	var got bytes.Buffer
+	var mu sync.Mutex
+	w := writerFunc(func(p []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return got.Write(p)
+	})
+
+	if err := b.Subscribe(w, SubscriberOptions{Policy: SpillToDisk, BufferBytes: 4, SpillDir: dir}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []byte("abcdefghijklmnopqrstuvwxyz")
+	for i := 0; i < len(want); i += 3 {
+		end := i + 3
+		if end > len(want) {
+			end = len(want)
+		}
+		writeWithTimeout(t, b, want[i:end], time.Second)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		n := got.Len()
+		mu.Unlock()
+		if n == len(want) || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got.String() != string(want) {
+		t.Fatalf("expected %q, got %q", want, got.String())
+	}
+
+	stats := b.Stats()
+	if stats[0].BytesSpilled == 0 {
+		t.Fatal("expected some bytes to have been spilled to disk")
+	}
+}
+
+// failingWriteCloser fails every Write and counts Close calls, so tests
+// can assert finish() never double-closes a subscriber's dst.
+type failingWriteCloser struct {
+	mu     sync.Mutex
+	closes int
+}
+
+func (w *failingWriteCloser) Write(p []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+func (w *failingWriteCloser) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closes++
+	return nil
+}
+
+func (w *failingWriteCloser) closeCount() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.closes
+}
+
+// TestBoundedDrainEvictionRemovesSubscriberOnce exercises the race the
+// unified finish method guards against: drain observes a failed dst.Write
+// and evicts the subscriber around the same time a subsequent Write call
+// might otherwise have tried to do the same thing. dst must be closed
+// exactly once, and the subscriber must not linger in Stats().
+func TestBoundedDrainEvictionRemovesSubscriberOnce(t *testing.T) {
+	b := NewBounded()
+	dst := &failingWriteCloser{}
+	if err := b.Subscribe(dst, SubscriberOptions{Policy: DropOldest, BufferBytes: 8}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	writeWithTimeout(t, b, []byte("abcd"), time.Second)
+	writeWithTimeout(t, b, []byte("efgh"), time.Second)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if len(b.Stats()) == 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if stats := b.Stats(); len(stats) != 0 {
+		t.Fatalf("expected the subscriber to be removed after a failed Write, got %d remaining", len(stats))
+	}
+	if got := dst.closeCount(); got != 1 {
+		t.Fatalf("expected dst to be closed exactly once, got %d", got)
+	}
+}
+
+// TestSpillFileReclaimsDiskSpaceAsItDrains verifies a spillFile's on-disk
+// size tracks the unread backlog rather than growing for as long as the
+// subscriber lives: once the reader catches up, the file is truncated
+// back down instead of retaining every byte ever spilled.
+func TestSpillFileReclaimsDiskSpaceAsItDrains(t *testing.T) {
+	dir := t.TempDir()
+	sf, err := newSpillFile(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer sf.close()
+
+	// Each write is fully drained by the read that follows it (it's well
+	// under readChunk()'s internal 32KB read-chunk cap), so readChunk()
+	// never finds the file already drained and its "fully drained"
+	// reclaim never runs. The file only shrinks when s.read reaches
+	// spillCompactThreshold and readChunk() calls reclaimLocked. Writing
+	// several multiples of spillCompactThreshold exercises that path
+	// repeatedly.
+	chunk := bytes.Repeat([]byte("x"), 4*1024)
+	const chunks = 600 // ~2.3MB total, several times spillCompactThreshold
+	for i := 0; i < chunks; i++ {
+		if err := sf.write(chunk, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := sf.readChunk(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	info, err := sf.f.Stat()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if max := int64(spillCompactThreshold) + int64(len(chunk)); info.Size() > max {
+		t.Fatalf("expected spill file on-disk size to stay bounded near spillCompactThreshold, got %d (wrote %d total)", info.Size(), len(chunk)*chunks)
+	}
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+func (writerFunc) Close() error                  { return nil }
+
+var _ io.WriteCloser = writerFunc(nil)